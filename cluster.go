@@ -0,0 +1,661 @@
+// Copyright (c) 2012 The gocql Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gocql
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPoolSize       = 2
+	defaultDiscoverPeriod = 0 // discovery only runs once at startup for now
+
+	minReconnectBackoff = 500 * time.Millisecond
+	maxReconnectBackoff = 1 * time.Minute
+)
+
+// Host is one node of a Cluster: a Cassandra endpoint together with the
+// pool of connections gocql keeps open to it and the token ranges it owns.
+type Host struct {
+	addr   string
+	tokens []int64 // in the order system.local/system.peers report them; TokenAwarePolicy sorts the ring itself
+
+	mu    sync.Mutex
+	conns []*connection
+	next  int // round-robin cursor into conns
+	up    bool
+}
+
+func (h *Host) pickConn() (*connection, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.up || len(h.conns) == 0 {
+		return nil, driver.ErrBadConn
+	}
+	cn := h.conns[h.next%len(h.conns)]
+	h.next++
+	if cn.c == nil {
+		return nil, driver.ErrBadConn
+	}
+	return cn, nil
+}
+
+// HostSelectionPolicy decides which Host a given request should be routed
+// to. Implementations must be safe for concurrent use.
+type HostSelectionPolicy interface {
+	// SetHosts is called whenever the Cluster's view of the ring changes.
+	SetHosts(hosts []*Host)
+	// PickHost returns the host that should serve a request. routingKey is
+	// the partition key of the request in its serialized wire form, or nil
+	// if the request has no known routing key (e.g. an ad-hoc query).
+	PickHost(routingKey []byte) (*Host, error)
+}
+
+// RoundRobinPolicy cycles through every known, healthy host in turn.
+type RoundRobinPolicy struct {
+	mu    sync.Mutex
+	hosts []*Host
+	next  int
+}
+
+func NewRoundRobinPolicy() *RoundRobinPolicy {
+	return &RoundRobinPolicy{}
+}
+
+func (p *RoundRobinPolicy) SetHosts(hosts []*Host) {
+	p.mu.Lock()
+	p.hosts = hosts
+	p.mu.Unlock()
+}
+
+func (p *RoundRobinPolicy) PickHost(routingKey []byte) (*Host, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.hosts)
+	if n == 0 {
+		return nil, fmt.Errorf("gocql: no hosts available")
+	}
+	for i := 0; i < n; i++ {
+		h := p.hosts[p.next%n]
+		p.next++
+		h.mu.Lock()
+		up := h.up
+		h.mu.Unlock()
+		if up {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("gocql: no hosts available")
+}
+
+// murmur3Partitioner is the only partitioner this driver knows how to
+// compute tokens for; Cluster.discover disables token awareness against
+// a ring reporting anything else (e.g. RandomPartitioner or
+// ByteOrderedPartitioner), since murmur3Token's output would be
+// meaningless there.
+const murmur3Partitioner = "org.apache.cassandra.dht.Murmur3Partitioner"
+
+// TokenAwarePolicy prefers the host that owns the token range a request's
+// routing key falls into, which avoids an extra network hop on the
+// coordinator. Requests without a routing key, or whose owning host is
+// down, fall back to the wrapped policy (typically a RoundRobinPolicy) --
+// as does every request once the ring is known not to use
+// Murmur3Partitioner; see disableMurmur3.
+type TokenAwarePolicy struct {
+	fallback HostSelectionPolicy
+
+	mu      sync.Mutex
+	tokens  []int64
+	owners  []*Host // owners[i] owns the range ending at tokens[i]
+	murmur3 bool
+}
+
+func NewTokenAwarePolicy(fallback HostSelectionPolicy) *TokenAwarePolicy {
+	if fallback == nil {
+		fallback = NewRoundRobinPolicy()
+	}
+	return &TokenAwarePolicy{fallback: fallback, murmur3: true}
+}
+
+// disableMurmur3 turns off token-based routing: PickHost falls back to
+// the wrapped policy for every request from then on, regardless of
+// routingKey. Cluster calls this once discovery learns the ring's
+// partitioner isn't Murmur3Partitioner.
+func (p *TokenAwarePolicy) disableMurmur3() {
+	p.mu.Lock()
+	p.murmur3 = false
+	p.mu.Unlock()
+}
+
+func (p *TokenAwarePolicy) SetHosts(hosts []*Host) {
+	p.fallback.SetHosts(hosts)
+
+	var tokens []int64
+	var owners []*Host
+	for _, h := range hosts {
+		for _, t := range h.tokens {
+			tokens = append(tokens, t)
+			owners = append(owners, h)
+		}
+	}
+	sort.Sort(tokenSlice{tokens, owners})
+
+	p.mu.Lock()
+	p.tokens = tokens
+	p.owners = owners
+	p.mu.Unlock()
+}
+
+func (p *TokenAwarePolicy) PickHost(routingKey []byte) (*Host, error) {
+	p.mu.Lock()
+	murmur3 := p.murmur3
+	p.mu.Unlock()
+	if routingKey == nil || !murmur3 {
+		return p.fallback.PickHost(routingKey)
+	}
+	token := murmur3Token(routingKey)
+
+	p.mu.Lock()
+	n := len(p.tokens)
+	if n == 0 {
+		p.mu.Unlock()
+		return p.fallback.PickHost(routingKey)
+	}
+	i := sort.Search(n, func(i int) bool { return p.tokens[i] >= token })
+	if i == n {
+		i = 0 // token wraps past the largest owned token back to the first range
+	}
+	owner := p.owners[i]
+	p.mu.Unlock()
+
+	owner.mu.Lock()
+	up := owner.up
+	owner.mu.Unlock()
+	if !up {
+		return p.fallback.PickHost(routingKey)
+	}
+	return owner, nil
+}
+
+// tokenSlice sorts tokens and their owning hosts together.
+type tokenSlice struct {
+	tokens []int64
+	owners []*Host
+}
+
+func (s tokenSlice) Len() int           { return len(s.tokens) }
+func (s tokenSlice) Less(i, j int) bool { return s.tokens[i] < s.tokens[j] }
+func (s tokenSlice) Swap(i, j int) {
+	s.tokens[i], s.tokens[j] = s.tokens[j], s.tokens[i]
+	s.owners[i], s.owners[j] = s.owners[j], s.owners[i]
+}
+
+// Cluster maintains a pool of connections spread across every node of a
+// Cassandra ring, discovered from system.peers/system.local, and routes
+// requests to them via a HostSelectionPolicy.
+type Cluster struct {
+	connOpts string // DSN options (minus hosts/pool_size/policy) applied to every connection
+	poolSize int
+	policy   HostSelectionPolicy
+
+	mu    sync.Mutex
+	hosts map[string]*Host
+
+	control   *connection // dedicated REGISTER'd connection, nil if events aren't wired up
+	events    chan Event
+	schemaGen int64 // bumped on every SCHEMA_CHANGE event; see clusterStatement.prepareOn
+}
+
+// NewCluster discovers the ring reachable from seeds and opens poolSize
+// connections to every node found, each configured with connOpts (e.g.
+// "keyspace=foo consistency=quorum"). policy is consulted by Prepare'd
+// statements to pick which host should serve each request.
+func NewCluster(seeds []string, poolSize int, policy HostSelectionPolicy, connOpts string) (*Cluster, error) {
+	if poolSize <= 0 {
+		poolSize = defaultPoolSize
+	}
+	if policy == nil {
+		policy = NewRoundRobinPolicy()
+	}
+	c := &Cluster{
+		connOpts: connOpts,
+		poolSize: poolSize,
+		policy:   policy,
+		hosts:    make(map[string]*Host),
+	}
+	if err := c.discover(seeds); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// discover opens a seed connection, reads the ring topology from
+// system.local and system.peers, then opens poolSize connections to every
+// address found and hands the result to the policy.
+func (c *Cluster) discover(seeds []string) error {
+	var seed *connection
+	var err error
+	for _, addr := range seeds {
+		seed, err = Open(strings.TrimSpace(addr) + " " + c.connOpts)
+		if err == nil {
+			break
+		}
+	}
+	if seed == nil {
+		return fmt.Errorf("gocql: could not reach any of %v: %s", seeds, err)
+	}
+
+	local, err := querySystemTable(seed, "SELECT tokens, partitioner FROM system.local")
+	if err != nil {
+		return err
+	}
+	localHost := &Host{addr: seed.c.RemoteAddr().String(), up: true}
+	if len(local) == 1 {
+		localHost.tokens = decodeTokens(local[0]["tokens"])
+		if partitioner, ok := local[0]["partitioner"].(string); ok && partitioner != murmur3Partitioner {
+			// murmur3Token only implements Murmur3Partitioner's hash, so
+			// token-aware routing would silently compute meaningless
+			// tokens against anything else; fall back to round-robin.
+			if tap, ok := c.policy.(*TokenAwarePolicy); ok {
+				tap.disableMurmur3()
+			}
+		}
+	}
+
+	peers, err := querySystemTable(seed, "SELECT peer, tokens FROM system.peers")
+	if err != nil {
+		return err
+	}
+
+	// system.peers reports bare addresses; peers are reached on the seed's port.
+	_, port, _ := net.SplitHostPort(localHost.addr)
+	hosts := []*Host{localHost}
+	for _, row := range peers {
+		addr, ok := row["peer"].(string)
+		if !ok {
+			continue
+		}
+		hosts = append(hosts, &Host{
+			addr:   net.JoinHostPort(addr, port),
+			tokens: decodeTokens(row["tokens"]),
+			up:     true,
+		})
+	}
+
+	for _, h := range hosts {
+		if err := c.fillPool(h); err != nil {
+			c.markDown(h) // keep the host in the ring, just unreachable until reconnect succeeds
+		}
+	}
+
+	c.mu.Lock()
+	for _, h := range hosts {
+		c.hosts[h.addr] = h
+	}
+	c.mu.Unlock()
+
+	c.policy.SetHosts(hosts)
+
+	if err := seed.Register([]string{"TOPOLOGY_CHANGE", "STATUS_CHANGE", "SCHEMA_CHANGE"}); err != nil {
+		seed.Close()
+		return nil // events are a bonus; a cluster that can't REGISTER still works
+	}
+	c.control = seed
+	c.events = make(chan Event, 64)
+	go c.forwardEvents()
+
+	return nil
+}
+
+// forwardEvents relays decoded EVENT frames from the control connection to
+// Events, and reacts to SCHEMA_CHANGE by invalidating cached prepared
+// statements (see clusterStatement.prepareOn).
+func (c *Cluster) forwardEvents() {
+	for {
+		select {
+		case ev := <-c.control.Events():
+			if _, ok := ev.(SchemaEvent); ok {
+				atomic.AddInt64(&c.schemaGen, 1)
+			}
+			select {
+			case c.events <- ev:
+			default: // a slow consumer must not stall event delivery to the driver itself
+			}
+		case <-c.control.closed:
+			return
+		}
+	}
+}
+
+// Events returns a channel of ring topology, node status and schema
+// change notifications observed by the cluster's control connection. It
+// is nil if the control connection could not REGISTER (e.g. talking to a
+// pre-CQL-3 server).
+func (c *Cluster) Events() <-chan Event {
+	return c.events
+}
+
+// fillPool opens c.poolSize connections to h, replacing h.conns.
+func (c *Cluster) fillPool(h *Host) error {
+	conns := make([]*connection, 0, c.poolSize)
+	for i := 0; i < c.poolSize; i++ {
+		cn, err := Open(h.addr + " " + c.connOpts)
+		if err != nil {
+			for _, cn := range conns {
+				cn.Close()
+			}
+			return err
+		}
+		conns = append(conns, cn)
+	}
+	h.mu.Lock()
+	h.conns = conns
+	h.up = true
+	h.mu.Unlock()
+	return nil
+}
+
+// reconnect retries fillPool with exponential backoff; it's meant to run
+// in its own goroutine whenever a host is marked down.
+func (c *Cluster) reconnect(h *Host) {
+	backoff := minReconnectBackoff
+	for {
+		time.Sleep(backoff)
+		if err := c.fillPool(h); err == nil {
+			return
+		}
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// pickHost derives a routing key from the first bound value (taken to be
+// the query's partition key, since the v1 prepared metadata this driver
+// parses doesn't flag partition-key columns) and asks the policy for a
+// host to serve it.
+//
+// This is a heuristic, not a guarantee: a statement whose partition key
+// isn't its first bound marker -- or whose partition key spans more than
+// one column -- has no routing key pickHost can find, and silently falls
+// back to whatever the wrapped policy (usually round-robin) picks. It
+// also only works at all once v[0] has already been converted to its
+// wire-encoded bytes by a ColumnConverter backed by real column
+// metadata; see Prepare, which prepares eagerly against one host so that
+// conversion is in place before the first Exec/Query.
+func (c *Cluster) pickHost(v []driver.Value) (*Host, error) {
+	var routingKey []byte
+	if len(v) > 0 {
+		if b, ok := v[0].([]byte); ok {
+			routingKey = b
+		}
+	}
+	return c.policy.PickHost(routingKey)
+}
+
+func (c *Cluster) markDown(h *Host) {
+	h.mu.Lock()
+	wasUp := h.up
+	h.up = false
+	h.mu.Unlock()
+	if wasUp {
+		go c.reconnect(h)
+	}
+}
+
+// Prepare prepares query against one host up front -- chosen by the
+// cluster's policy, ignoring routing since no bound values exist yet --
+// and returns a statement that re-selects (and lazily prepares on)
+// further hosts via the policy on every subsequent Exec/Query.
+//
+// Preparing eagerly, rather than waiting for the first Exec/Query, means
+// ColumnConverter and NumInput already reflect the query's real column
+// metadata before database/sql converts that first call's arguments.
+// Without it, those arguments are encoded by DefaultParameterConverter
+// instead of the CQL-typed columnEncoder, so pickHost never sees a
+// bound value it recognizes as a routing key and token-aware routing
+// never engages.
+func (c *Cluster) Prepare(query string) (driver.Stmt, error) {
+	cs := &clusterStatement{
+		cluster:  c,
+		query:    query,
+		numInput: -1,
+		perHost:  make(map[*Host]*statement),
+	}
+	h, err := c.policy.PickHost(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cs.prepareOn(h); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// clusterStatement defers connection selection to each Exec/Query call so
+// that a single database/sql prepared statement can be routed to whichever
+// host the policy currently prefers, rather than being pinned to the host
+// it happened to reach first.
+type clusterStatement struct {
+	cluster *Cluster
+	query   string
+
+	mu        sync.Mutex
+	numInput  int
+	perHost   map[*Host]*statement
+	schemaGen int64 // last schemaGen we prepared against; see prepareOn
+}
+
+func (cs *clusterStatement) Close() error { return nil }
+
+func (cs *clusterStatement) NumInput() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.numInput
+}
+
+func (cs *clusterStatement) ColumnConverter(idx int) driver.ValueConverter {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, st := range cs.perHost {
+		return st.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+// prepareOn returns the real *statement for h, preparing it there the
+// first time it's needed.
+func (cs *clusterStatement) prepareOn(h *Host) (*statement, error) {
+	cs.mu.Lock()
+	if gen := atomic.LoadInt64(&cs.cluster.schemaGen); gen != cs.schemaGen {
+		// a SCHEMA_CHANGE happened since we last prepared; the cached
+		// prepared statement ids may now point at stale metadata, so
+		// force every host to re-PREPARE on next use.
+		cs.perHost = make(map[*Host]*statement)
+		cs.schemaGen = gen
+	}
+	if st, ok := cs.perHost[h]; ok {
+		cs.mu.Unlock()
+		return st, nil
+	}
+	cs.mu.Unlock()
+
+	cn, err := h.pickConn()
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := cn.Prepare(cs.query)
+	if err != nil {
+		cs.cluster.markDown(h)
+		return nil, err
+	}
+	st := stmt.(*statement)
+
+	cs.mu.Lock()
+	cs.perHost[h] = st
+	cs.numInput = st.NumInput()
+	cs.mu.Unlock()
+	return st, nil
+}
+
+func (cs *clusterStatement) Exec(v []driver.Value) (driver.Result, error) {
+	h, err := cs.cluster.pickHost(v)
+	if err != nil {
+		return nil, err
+	}
+	st, err := cs.prepareOn(h)
+	if err != nil {
+		return nil, err
+	}
+	return st.Exec(v)
+}
+
+func (cs *clusterStatement) Query(v []driver.Value) (driver.Rows, error) {
+	h, err := cs.cluster.pickHost(v)
+	if err != nil {
+		return nil, err
+	}
+	st, err := cs.prepareOn(h)
+	if err != nil {
+		return nil, err
+	}
+	return st.Query(v)
+}
+
+// clusterConn is the driver.Conn database/sql holds for a cluster-mode DSN.
+// Each call to drv.Open with a "hosts=" DSN hands back a fresh clusterConn
+// over the same underlying Cluster, mirroring how a plain *connection is a
+// thin, disposable handle onto one TCP socket.
+type clusterConn struct {
+	cluster *Cluster
+}
+
+func (cc *clusterConn) Prepare(query string) (driver.Stmt, error) {
+	return cc.cluster.Prepare(query)
+}
+
+func (cc *clusterConn) Close() error { return nil }
+
+func (cc *clusterConn) Begin() (driver.Tx, error) { return cc, nil }
+func (cc *clusterConn) Commit() error             { return nil }
+func (cc *clusterConn) Rollback() error           { return nil }
+
+// clusterDSN reports whether name carries a "hosts=" option and, if so,
+// splits it into the seed address list and the remaining options (e.g.
+// "pool_size=4 policy=token_aware keyspace=foo") to forward to each
+// per-host connection.
+func clusterDSN(name string) (seeds []string, poolSize int, policy HostSelectionPolicy, rest string, ok bool) {
+	parts := strings.Split(name, " ")
+	var restParts []string
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			restParts = append(restParts, part)
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "hosts":
+			seeds = strings.Split(kv[1], ",")
+			ok = true
+		case "pool_size":
+			if n, err := strconv.Atoi(kv[1]); err == nil {
+				poolSize = n
+			}
+		case "policy":
+			switch strings.ToLower(kv[1]) {
+			case "token_aware":
+				policy = NewTokenAwarePolicy(NewRoundRobinPolicy())
+			case "round_robin", "":
+				policy = NewRoundRobinPolicy()
+			}
+		default:
+			restParts = append(restParts, part)
+		}
+	}
+	rest = strings.Join(restParts, " ")
+	return
+}
+
+// querySystemTable runs a plain (unprepared) CQL query over cn and decodes
+// its rows into column-name-keyed maps. It's only ever used for discovery
+// queries against system.local/system.peers.
+func querySystemTable(cn *connection, cql string) ([]map[string]interface{}, error) {
+	body := buildQuery(cn.protocolVersion, cql, cn.readConsistency)
+
+	opcode, resp, err := cn.retryCall(opQuery, body)
+	if err != nil {
+		return nil, err
+	}
+	kind := binary.BigEndian.Uint32(resp[0:4])
+	if opcode != opResult || kind != 2 {
+		return nil, fmt.Errorf("gocql: expected rows result for %q", cql)
+	}
+
+	columns, meta, _, n := parseMeta(resp[4:])
+	i := n + 4
+	numRows := int(binary.BigEndian.Uint32(resp[i:]))
+	i += 4
+
+	rows := make([]map[string]interface{}, 0, numRows)
+	for r := 0; r < numRows; r++ {
+		row := make(map[string]interface{}, len(columns))
+		for c, col := range columns {
+			ln := int32(binary.BigEndian.Uint32(resp[i:]))
+			i += 4
+			if ln >= 0 {
+				row[col] = decode(resp[i:i+int(ln)], meta[c])
+				i += int(ln)
+			} else {
+				row[col] = nil
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// decodeTokens normalizes the "tokens" column (a set<text> of decimal
+// Murmur3 token strings) into the []int64 form the ring math above wants.
+func decodeTokens(v interface{}) []int64 {
+	var out []int64
+	switch vv := v.(type) {
+	case []string:
+		for _, s := range vv {
+			if t, err := strconv.ParseInt(s, 10, 64); err == nil {
+				out = append(out, t)
+			}
+		}
+	case string:
+		if t, err := strconv.ParseInt(vv, 10, 64); err == nil {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func openCluster(name string) (driver.Conn, error) {
+	seeds, poolSize, policy, rest, ok := clusterDSN(name)
+	if !ok {
+		return nil, fmt.Errorf("gocql: not a cluster DSN")
+	}
+	cluster, err := NewCluster(seeds, poolSize, policy, rest)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterConn{cluster: cluster}, nil
+}
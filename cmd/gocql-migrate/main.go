@@ -0,0 +1,197 @@
+// Copyright (c) 2012 The gocql Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command gocql-migrate applies a directory of CQL migration files to a
+// keyspace through the migrate package.
+//
+// Migrations live as pairs of files named NNNN_name.up.cql and
+// NNNN_name.down.cql; NNNN_name is used as the migration ID, and files
+// are applied in lexical order. Each file may hold more than one
+// statement, separated by ";".
+//
+// Usage:
+//
+//	gocql-migrate -dsn "localhost:9042 keyspace=myapp" -dir migrations up
+//	gocql-migrate -dsn "localhost:9042 keyspace=myapp" -dir migrations down
+//	gocql-migrate -dsn "localhost:9042 keyspace=myapp" -dir migrations to 0002_add_users
+//	gocql-migrate -dsn "localhost:9042 keyspace=myapp" -dir migrations status
+//	gocql-migrate -dsn "localhost:9042 keyspace=myapp" -dir migrations -dry-run up
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	_ "github.com/brandscreen/gocqldriver"
+	"github.com/brandscreen/gocqldriver/migrate"
+)
+
+var migrationFileRE = regexp.MustCompile(`^(.+)\.(up|down)\.cql$`)
+
+// loadMigrations scans dir for NNNN_name.up.cql/.down.cql pairs and
+// returns them as Migrations in lexical ID order. A migration missing
+// its .down.cql file is loaded with a nil Down.
+func loadMigrations(dir string) ([]migrate.Migration, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("gocql-migrate: %s", err)
+	}
+
+	ups := map[string]string{}
+	downs := map[string]string{}
+	for _, entry := range entries {
+		m := migrationFileRE.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		switch m[2] {
+		case "up":
+			ups[m[1]] = path
+		case "down":
+			downs[m[1]] = path
+		}
+	}
+
+	ids := make([]string, 0, len(ups))
+	for id := range ups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	migrations := make([]migrate.Migration, len(ids))
+	for i, id := range ids {
+		migrations[i] = migrate.Migration{
+			ID:   id,
+			Up:   execFile(ups[id]),
+			Down: execFile(downs[id]),
+		}
+	}
+	return migrations, nil
+}
+
+// execFile returns a Migration step that runs every statement in path
+// against its *sql.Tx, in order. A nil tx -- what Migrator passes in
+// DryRun mode -- prints each statement instead of running it. execFile
+// returns nil if path is empty, leaving the corresponding Migration
+// field unset.
+func execFile(path string) func(*sql.Tx) error {
+	if path == "" {
+		return nil
+	}
+	return func(tx *sql.Tx) error {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range splitStatements(string(content)) {
+			if tx == nil {
+				fmt.Printf("%s;\n", stmt)
+				continue
+			}
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("%s: %s", filepath.Base(path), err)
+			}
+		}
+		return nil
+	}
+}
+
+// splitStatements splits a .cql file's content on statement-terminating
+// ";" characters, ignoring any that fall inside a '...'-quoted string
+// literal so a semicolon in a value doesn't split the statement in two.
+func splitStatements(cql string) []string {
+	var stmts []string
+	var cur strings.Builder
+	inString := false
+	for _, r := range cql {
+		switch r {
+		case '\'':
+			inString = !inString
+			cur.WriteRune(r)
+		case ';':
+			if inString {
+				cur.WriteRune(r)
+				continue
+			}
+			if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+func main() {
+	dsn := flag.String("dsn", "", `gocql DSN, e.g. "localhost:9042 keyspace=myapp"`)
+	dir := flag.String("dir", "migrations", "directory of NNNN_name.up.cql/.down.cql files")
+	dryRun := flag.Bool("dry-run", false, "print the CQL that would be executed instead of running it")
+	flag.Parse()
+
+	if *dsn == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: gocql-migrate -dsn DSN [-dir DIR] [-dry-run] up|down|to ID|status")
+		os.Exit(1)
+	}
+
+	migrations, err := loadMigrations(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	db, err := sql.Open("gocql", *dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gocql-migrate: %s\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	m := migrate.NewMigrator(db, migrations)
+	m.DryRun = *dryRun
+
+	args := flag.Args()
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		err = m.Down()
+	case "to":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: gocql-migrate ... to ID")
+			os.Exit(1)
+		}
+		err = m.To(args[1])
+	case "status":
+		var statuses []migrate.Status
+		if statuses, err = m.Status(); err == nil {
+			for _, s := range statuses {
+				mark := " "
+				if s.Applied {
+					mark = "x"
+				}
+				fmt.Printf("[%s] %s\n", mark, s.ID)
+			}
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "gocql-migrate: unknown command %q\n", args[0])
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gocql-migrate: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2012 The gocql Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gocql
+
+import (
+	"code.google.com/p/snappy-go/snappy"
+	"encoding/binary"
+	"fmt"
+	"github.com/pierrec/lz4"
+)
+
+// minCompressSize is the smallest frame body gocql will bother compressing:
+// below it, algorithm and framing overhead outweighs any savings, so the
+// frame goes out uncompressed even when compression is negotiated. This
+// matches the threshold upstream gocql uses.
+const minCompressSize = 64
+
+// compressBody compresses body with algo ("snappy" or "lz4") unless it's
+// too small to be worth it, in which case it's returned unchanged with
+// compressed=false so the caller leaves flagCompressed unset.
+func compressBody(algo string, body []byte) (out []byte, compressed bool, err error) {
+	if len(body) < minCompressSize {
+		return body, false, nil
+	}
+	switch algo {
+	case "snappy":
+		out, err = snappy.Encode(nil, body)
+	case "lz4":
+		var ok bool
+		out, ok, err = lz4Encode(body)
+		if err == nil && !ok {
+			// CompressBlock reports an incompressible block this way,
+			// which is routine for already-dense data -- fall back to
+			// sending the frame uncompressed instead of failing it.
+			return body, false, nil
+		}
+	default:
+		return body, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// decompressBody reverses compressBody for a frame whose flagCompressed
+// bit is set, using whichever algorithm the connection negotiated.
+func decompressBody(algo string, body []byte) ([]byte, error) {
+	switch algo {
+	case "snappy":
+		return snappy.Decode(nil, body)
+	case "lz4":
+		return lz4Decode(body)
+	default:
+		return nil, fmt.Errorf("gocql: received compressed frame but no algorithm is negotiated")
+	}
+}
+
+// lz4Encode compresses data as a raw LZ4 block prefixed with a 4-byte
+// big-endian uncompressed length, which is the framing Cassandra's native
+// protocol expects -- notably different from the little-endian header
+// most standalone LZ4 tools and libraries, including pierrec/lz4's own
+// convenience helpers, use.
+//
+// ok is false if CompressBlock reports data as incompressible (its usual
+// signal for blocks that are already dense, e.g. blobs or random data);
+// callers should fall back to sending data uncompressed rather than
+// treating that as an error.
+func lz4Encode(data []byte) (out []byte, ok bool, err error) {
+	buf := make([]byte, 4, len(data)+4)
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+
+	block := make([]byte, lz4.CompressBlockBound(len(data)))
+	var c lz4.Compressor
+	n, err := c.CompressBlock(data, block)
+	if err != nil {
+		return nil, false, err
+	}
+	if n == 0 {
+		return nil, false, nil
+	}
+	return append(buf, block[:n]...), true, nil
+}
+
+// lz4Decode reverses lz4Encode: it strips the 4-byte big-endian
+// uncompressed length Cassandra prepends, then inflates the raw LZ4 block
+// that follows it.
+func lz4Decode(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("gocql: lz4 frame shorter than its length header")
+	}
+	uncompressedLen := binary.BigEndian.Uint32(data[:4])
+	dst := make([]byte, uncompressedLen)
+	n, err := lz4.UncompressBlock(data[4:], dst)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n], nil
+}
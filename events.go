@@ -0,0 +1,108 @@
+// Copyright (c) 2012 The gocql Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gocql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Event is the common interface implemented by every decoded EVENT frame.
+type Event interface {
+	// Kind returns the native protocol event type, e.g. "SCHEMA_CHANGE".
+	Kind() string
+}
+
+// TopologyEvent reports a node joining or leaving the ring.
+type TopologyEvent struct {
+	Change string // "NEW_NODE" or "REMOVED_NODE"
+	Addr   string
+	Port   int
+}
+
+func (e TopologyEvent) Kind() string { return "TOPOLOGY_CHANGE" }
+
+// StatusEvent reports a node going up or down.
+type StatusEvent struct {
+	Change string // "UP" or "DOWN"
+	Addr   string
+	Port   int
+}
+
+func (e StatusEvent) Kind() string { return "STATUS_CHANGE" }
+
+// SchemaEvent reports a keyspace, table or type being created, updated or
+// dropped. Table is empty for a keyspace-level change.
+type SchemaEvent struct {
+	Change   string // "CREATED", "UPDATED" or "DROPPED"
+	Keyspace string
+	Table    string
+}
+
+func (e SchemaEvent) Kind() string { return "SCHEMA_CHANGE" }
+
+// decodeEvent parses the body of an EVENT frame into one of the typed
+// Event implementations above.
+func decodeEvent(body []byte) (Event, error) {
+	eventType, i := readShortString(body, 0)
+	switch eventType {
+	case "TOPOLOGY_CHANGE":
+		change, j := readShortString(body, i)
+		addr, port, _ := readInet(body, j)
+		return TopologyEvent{Change: change, Addr: addr, Port: port}, nil
+	case "STATUS_CHANGE":
+		change, j := readShortString(body, i)
+		addr, port, _ := readInet(body, j)
+		return StatusEvent{Change: change, Addr: addr, Port: port}, nil
+	case "SCHEMA_CHANGE":
+		change, j := readShortString(body, i)
+		keyspace, k := readShortString(body, j)
+		table, _ := readShortString(body, k)
+		return SchemaEvent{Change: change, Keyspace: keyspace, Table: table}, nil
+	default:
+		return nil, fmt.Errorf("gocql: unknown event type %q", eventType)
+	}
+}
+
+// readShortString decodes a native protocol [string] (a uint16 length
+// prefix followed by that many bytes) starting at offset i.
+func readShortString(body []byte, i int) (s string, next int) {
+	n := int(binary.BigEndian.Uint16(body[i:]))
+	i += 2
+	return string(body[i : i+n]), i + n
+}
+
+// readInet decodes a native protocol [inet]: a length-prefixed 4- or
+// 16-byte address followed by a 4-byte port.
+func readInet(body []byte, i int) (addr string, port int, next int) {
+	n := int(body[i])
+	i++
+	ip := net.IP(body[i : i+n])
+	i += n
+	port = int(binary.BigEndian.Uint32(body[i:]))
+	i += 4
+	return ip.String(), port, i
+}
+
+// encodeStringList builds the wire form of a native protocol [string list]:
+// a uint16 count followed by that many [string]s. It's used to build the
+// REGISTER body.
+func encodeStringList(vals []string) []byte {
+	sz := 2
+	for _, v := range vals {
+		sz += 2 + len(v)
+	}
+	body := make([]byte, sz)
+	binary.BigEndian.PutUint16(body, uint16(len(vals)))
+	i := 2
+	for _, v := range vals {
+		binary.BigEndian.PutUint16(body[i:], uint16(len(v)))
+		i += 2
+		copy(body[i:], v)
+		i += len(v)
+	}
+	return body
+}
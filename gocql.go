@@ -30,7 +30,6 @@ package gocql
 
 import (
 	"bytes"
-	"code.google.com/p/snappy-go/snappy"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/binary"
@@ -40,13 +39,11 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	protoRequest  byte = 0x01
-	protoResponse byte = 0x81
-
 	opError        byte = 0x00
 	opStartup      byte = 0x01
 	opReady        byte = 0x02
@@ -58,7 +55,22 @@ const (
 	opResult       byte = 0x08
 	opPrepare      byte = 0x09
 	opExecute      byte = 0x0A
-	opLAST         byte = 0x0A // not a real opcode -- used to check for valid opcodes
+	opRegister     byte = 0x0B
+	opEvent        byte = 0x0C
+	opBatch        byte = 0x0D
+	opLAST         byte = 0x0D // not a real opcode -- used to check for valid opcodes
+
+	// eventStream is the stream id Cassandra tags unsolicited EVENT frames
+	// with; it never matches a stream allocated by allocStream.
+	eventStream byte = 0xFF
+
+	// protoDirectionResponse is OR'd into a frame's version byte to mark it
+	// as a response; a request frame just carries the negotiated version.
+	protoDirectionResponse byte = 0x80
+
+	// defaultProtocolVersion is the native protocol version gocql speaks
+	// unless a DSN "protocol=" option asks for an older one.
+	defaultProtocolVersion byte = 2
 
 	errorOverloaded   = 0x1001
 	errorWriteTimeout = 0x1100
@@ -68,6 +80,16 @@ const (
 
 	keyVersion     string = "CQL_VERSION"
 	keyCompression string = "COMPRESSION"
+
+	// numStreams is the number of concurrent in-flight requests the v1 native
+	// protocol allows per connection: stream ids are a single byte, and the
+	// high bit is reserved for the server (0x80-0xff are used for events and
+	// error responses), so only 0-127 are available to the client.
+	numStreams = 128
+
+	// defaultTimeout bounds how long a request will wait for its response
+	// before the connection is assumed to be wedged.
+	defaultTimeout = 30 * time.Second
 )
 
 var consistencyLevels = map[string]byte{"any": 0x00, "one": 0x01, "two": 0x02,
@@ -76,6 +98,9 @@ var consistencyLevels = map[string]byte{"any": 0x00, "one": 0x01, "two": 0x02,
 type drv struct{}
 
 func (d drv) Open(name string) (driver.Conn, error) {
+	if strings.Contains(strings.ToLower(name), "hosts=") {
+		return openCluster(name)
+	}
 	return Open(name)
 }
 
@@ -85,6 +110,28 @@ type connection struct {
 	readConsistency, writeConsistency byte
 	recycle                           time.Time
 	retries                           int
+	timeout                           time.Duration
+	protocolVersion                   byte
+	pageSize                          int32 // default automatic-paging page size; 0 disables paging
+
+	writeMu sync.Mutex // serializes frame writes onto c
+
+	streamsMu sync.Mutex
+	streams   []bool // true if stream id is in use
+	pending   map[byte]chan response
+
+	events chan Event // unsolicited TOPOLOGY_CHANGE/STATUS_CHANGE/SCHEMA_CHANGE frames, once Register'd
+
+	closeOnce sync.Once
+	closed    chan struct{} // closed once the reader goroutine exits
+}
+
+// response is what the reader goroutine hands back to the goroutine
+// blocked on a given stream id.
+type response struct {
+	opcode byte
+	body   []byte
+	err    error
 }
 
 // dial addresses until we connect
@@ -112,6 +159,12 @@ func Open(name string) (cn *connection, err error) {
 	cn = &connection{
 		readConsistency:  consistencyLevels["one"],
 		writeConsistency: consistencyLevels["one"],
+		timeout:          defaultTimeout,
+		protocolVersion:  defaultProtocolVersion,
+		streams:          make([]bool, numStreams),
+		pending:          make(map[byte]chan response),
+		events:           make(chan Event, 64),
+		closed:           make(chan struct{}),
 	}
 	var keyspace string
 
@@ -131,7 +184,7 @@ func Open(name string) (cn *connection, err error) {
 			keyspace = val
 		case "compression":
 			val = strings.ToLower(val)
-			if val != "snappy" {
+			if val != "snappy" && val != "lz4" {
 				err = fmt.Errorf("unknown compression algorithm %q", val)
 				return
 			}
@@ -171,6 +224,23 @@ func Open(name string) (cn *connection, err error) {
 				return nil, fmt.Errorf("bad retries option: %s", err)
 			}
 			cn.retries = int(i64)
+		case "timeout":
+			cn.timeout, err = time.ParseDuration(val)
+			if err != nil {
+				return nil, fmt.Errorf("bad timeout option: %s", err)
+			}
+		case "protocol":
+			i64, err := strconv.ParseInt(val, 0, 0)
+			if err != nil || i64 < 1 || i64 > 2 {
+				return nil, fmt.Errorf("bad protocol option %q: must be 1 or 2", val)
+			}
+			cn.protocolVersion = byte(i64)
+		case "page_size":
+			i64, err := strconv.ParseInt(val, 0, 0)
+			if err != nil {
+				return nil, fmt.Errorf("bad page_size option: %s", err)
+			}
+			cn.pageSize = int32(i64)
 		default:
 			return nil, fmt.Errorf("unsupported option %q", opt)
 		}
@@ -181,6 +251,8 @@ func Open(name string) (cn *connection, err error) {
 		return nil, err
 	}
 
+	go cn.readLoop()
+
 	b := &bytes.Buffer{}
 
 	if cn.compression != "" {
@@ -201,11 +273,7 @@ func Open(name string) (cn *connection, err error) {
 		b.WriteString(cn.compression)
 	}
 
-	if err := cn.send(opStartup, b.Bytes()); err != nil {
-		return nil, err
-	}
-
-	opcode, _, err := cn.recv()
+	opcode, _, err := cn.call(opStartup, b.Bytes())
 	if err != nil {
 		return nil, err
 	}
@@ -227,84 +295,217 @@ func Open(name string) (cn *connection, err error) {
 }
 
 // close a connection actively, typically used when there's an error and we want to ensure
-// we don't repeatedly try to use the broken connection
+// we don't repeatedly try to use the broken connection. It fails every outstanding
+// request with driver.ErrBadConn and can safely be called more than once.
 func (cn *connection) close() {
-	cn.c.Close()
-	cn.c = nil // ensure we generate ErrBadConn when cn gets reused
+	cn.closeOnce.Do(func() {
+		cn.c.Close()
+		cn.c = nil // ensure we generate ErrBadConn when cn gets reused
+
+		cn.streamsMu.Lock()
+		pending := make([]chan response, 0, len(cn.pending))
+		for stream, ch := range cn.pending {
+			pending = append(pending, ch)
+			delete(cn.pending, stream)
+		}
+		cn.streamsMu.Unlock()
+
+		// Signal waiters with streamsMu released: each channel is
+		// buffered for exactly one response, and if readLoop already
+		// delivered one for a stream whose caller hasn't drained it
+		// yet, sending here while holding the lock would block and
+		// stall every other goroutine waiting on allocStream/freeStream.
+		for _, ch := range pending {
+			ch <- response{err: driver.ErrBadConn}
+		}
+
+		close(cn.closed)
+	})
+}
+
+// allocStream reserves a free stream id for the lifetime of one request,
+// blocking if all numStreams ids are currently in flight.
+func (cn *connection) allocStream() (byte, chan response, error) {
+	for {
+		cn.streamsMu.Lock()
+		for i, inUse := range cn.streams {
+			if !inUse {
+				cn.streams[i] = true
+				ch := make(chan response, 1)
+				cn.pending[byte(i)] = ch
+				cn.streamsMu.Unlock()
+				return byte(i), ch, nil
+			}
+		}
+		cn.streamsMu.Unlock()
+		select {
+		case <-cn.closed:
+			return 0, nil, driver.ErrBadConn
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// freeStream returns a stream id to the pool once its response has been
+// consumed (or the request failed before a response ever arrived).
+func (cn *connection) freeStream(stream byte) {
+	cn.streamsMu.Lock()
+	delete(cn.pending, stream)
+	cn.streams[stream] = false
+	cn.streamsMu.Unlock()
 }
 
-func (cn *connection) send(opcode byte, body []byte) error {
+func (cn *connection) writeFrame(stream byte, opcode byte, body []byte) error {
 	if cn.c == nil {
 		return driver.ErrBadConn
 	}
+	var flags byte
+	// STARTUP negotiates compression; the server has no algorithm to
+	// decompress it with yet, so it -- and anything else sent before
+	// STARTUP/READY completes -- must always go out uncompressed.
+	if cn.compression != "" && opcode != opStartup {
+		compressed, ok, err := compressBody(cn.compression, body)
+		if err != nil {
+			return err
+		}
+		if ok {
+			body = compressed
+			flags |= flagCompressed
+		}
+	}
 	frame := make([]byte, len(body)+8)
-	frame[0] = protoRequest
-	frame[1] = 0
-	frame[2] = 0
+	frame[0] = cn.protocolVersion
+	frame[1] = flags
+	frame[2] = stream
 	frame[3] = opcode
 	binary.BigEndian.PutUint32(frame[4:8], uint32(len(body)))
 	copy(frame[8:], body)
-	if _, err := cn.c.Write(frame); err != nil {
+	cn.writeMu.Lock()
+	_, err := cn.c.Write(frame)
+	cn.writeMu.Unlock()
+	if err != nil {
+		cn.close()
 		return err
 	}
 	return nil
 }
 
-func (cn *connection) recv() (byte, []byte, error) {
-	if cn.c == nil {
+// call sends a request and blocks until its matching response is dispatched
+// by readLoop, or cn.timeout elapses. It is safe to call concurrently from
+// many goroutines sharing the same connection.
+func (cn *connection) call(opcode byte, body []byte) (byte, []byte, error) {
+	stream, ch, err := cn.allocStream()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer cn.freeStream(stream)
+
+	if err := cn.writeFrame(stream, opcode, body); err != nil {
+		return 0, nil, err
+	}
+
+	select {
+	case r := <-ch:
+		return r.opcode, r.body, r.err
+	case <-time.After(cn.timeout):
+		// The response may still land on the socket after we give up
+		// waiting for it. Closing the connection, rather than just
+		// freeing the stream, keeps that stale frame from ever being
+		// matched to whatever later request reuses stream id.
+		cn.close()
+		return 0, nil, fmt.Errorf("gocql: timeout waiting for response on stream %d", stream)
+	case <-cn.closed:
 		return 0, nil, driver.ErrBadConn
 	}
+}
+
+// readLoop owns the read side of the socket for the lifetime of the
+// connection. It decodes one frame at a time and dispatches the body to
+// whichever goroutine is waiting on that frame's stream id, which lets many
+// callers share a single TCP connection instead of serializing on it.
+func (cn *connection) readLoop() {
+	for {
+		opcode, stream, body, err := cn.readFrame()
+		if err != nil {
+			// a transport or protocol-level failure means the connection
+			// itself is no longer usable, so every outstanding request
+			// (and any future one) must fail.
+			cn.close()
+			return
+		}
+		if opcode == opEvent {
+			if ev, err := decodeEvent(body); err == nil {
+				select {
+				case cn.events <- ev:
+				default: // a slow or absent consumer must never stall the reader
+				}
+			}
+			continue
+		}
+		var appErr error
+		if opcode == opError {
+			code := binary.BigEndian.Uint32(body[0:4])
+			msglen := binary.BigEndian.Uint16(body[4:6])
+			msg := string(body[6 : 6+msglen])
+			appErr = Error{Code: int(code), Msg: msg}
+		}
+		cn.streamsMu.Lock()
+		ch, ok := cn.pending[stream]
+		cn.streamsMu.Unlock()
+		if !ok {
+			// no one is waiting for this stream (e.g. it already timed
+			// out) or it's an unsolicited frame we don't handle yet.
+			continue
+		}
+		ch <- response{opcode: opcode, body: body, err: appErr}
+	}
+}
+
+// readFrame reads and decodes a single frame from the wire. It does not
+// interpret the stream id beyond returning it to the caller, and a non-nil
+// err always means the connection itself is broken (an application-level
+// error response is reported via the opError opcode instead).
+func (cn *connection) readFrame() (opcode byte, stream byte, body []byte, err error) {
+	if cn.c == nil {
+		return 0, 0, nil, driver.ErrBadConn
+	}
 	header := make([]byte, 8)
 	if _, err := io.ReadFull(cn.c, header); err != nil {
-		cn.close() // better assume that the connection is broken (may have read some bytes)
-		return 0, nil, err
+		return 0, 0, nil, err
 	}
 	// verify that the frame starts with version==1 and req/resp flag==response
 	// this may be overly conservative in that future versions may be backwards compatible
 	// in that case simply amend the check...
-	if header[0] != protoResponse {
-		cn.close()
-		return 0, nil, fmt.Errorf("unsupported frame version or not a response: 0x%x (header=%v)", header[0], header)
+	if header[0] != protoDirectionResponse|cn.protocolVersion {
+		return 0, 0, nil, fmt.Errorf("unsupported frame version or not a response: 0x%x (header=%v)", header[0], header)
 	}
 	// verify that the flags field has only a single flag set, again, this may
 	// be overly conservative if additional flags are backwards-compatible
 	if header[1] > 1 {
-		cn.close()
-		return 0, nil, fmt.Errorf("unsupported frame flags: 0x%x (header=%v)", header[1], header)
+		return 0, 0, nil, fmt.Errorf("unsupported frame flags: 0x%x (header=%v)", header[1], header)
 	}
-	opcode := header[3]
+	stream = header[2]
+	opcode = header[3]
 	if opcode > opLAST {
-		cn.close()
-		return 0, nil, fmt.Errorf("unknown opcode: 0x%x (header=%v)", opcode, header)
+		return 0, 0, nil, fmt.Errorf("unknown opcode: 0x%x (header=%v)", opcode, header)
 	}
 	length := binary.BigEndian.Uint32(header[4:8])
-	var body []byte
 	if length > 0 {
 		if length > 256*1024*1024 { // spec says 256MB is max
-			cn.close()
-			return 0, nil, fmt.Errorf("frame too large: %d (header=%v)", length, header)
+			return 0, 0, nil, fmt.Errorf("frame too large: %d (header=%v)", length, header)
 		}
 		body = make([]byte, length)
 		if _, err := io.ReadFull(cn.c, body); err != nil {
-			cn.close() // better assume that the connection is broken
-			return 0, nil, err
+			return 0, 0, nil, err
 		}
 	}
-	if header[1]&flagCompressed != 0 && cn.compression == "snappy" {
-		var err error
-		body, err = snappy.Decode(nil, body)
+	if header[1]&flagCompressed != 0 {
+		body, err = decompressBody(cn.compression, body)
 		if err != nil {
-			cn.close()
-			return 0, nil, err
+			return 0, 0, nil, err
 		}
 	}
-	if opcode == opError {
-		code := binary.BigEndian.Uint32(body[0:4])
-		msglen := binary.BigEndian.Uint16(body[4:6])
-		msg := string(body[6 : 6+msglen])
-		return opcode, body, Error{Code: int(code), Msg: msg}
-	}
-	return opcode, body, nil
+	return opcode, stream, body, nil
 }
 
 func (cn *connection) Begin() (driver.Tx, error) {
@@ -365,13 +566,9 @@ func retryErr(err error) bool {
 	return true
 }
 
-func (cn *connection) retrySendRecv(send func() error) (op byte, body []byte, err error) {
+func (cn *connection) retryCall(opcode byte, body []byte) (op byte, respBody []byte, err error) {
 	for try := 0; try <= cn.retries || cn.retries < 0; try++ {
-		err = send()
-		if err != nil {
-			break
-		}
-		op, body, err = cn.recv()
+		op, respBody, err = cn.call(opcode, body)
 		if err == nil {
 			break
 		}
@@ -382,6 +579,27 @@ func (cn *connection) retrySendRecv(send func() error) (op byte, body []byte, er
 	return
 }
 
+// Register subscribes this connection to the given native protocol event
+// types (e.g. "TOPOLOGY_CHANGE", "STATUS_CHANGE", "SCHEMA_CHANGE"). Once
+// registered, matching EVENT frames are decoded and delivered on the
+// channel returned by Events instead of being silently dropped.
+func (cn *connection) Register(eventTypes []string) error {
+	opcode, _, err := cn.retryCall(opRegister, encodeStringList(eventTypes))
+	if err != nil {
+		return err
+	}
+	if opcode != opReady {
+		return fmt.Errorf("gocql: unexpected response to REGISTER: opcode 0x%x", opcode)
+	}
+	return nil
+}
+
+// Events returns the channel EVENT frames are delivered on. It is only
+// useful after a call to Register.
+func (cn *connection) Events() <-chan Event {
+	return cn.events
+}
+
 func (cn *connection) Prepare(query string) (driver.Stmt, error) {
 	if err := cn.recycleErr(); err != nil {
 		return nil, err
@@ -389,9 +607,7 @@ func (cn *connection) Prepare(query string) (driver.Stmt, error) {
 	body := make([]byte, len(query)+4)
 	binary.BigEndian.PutUint32(body[0:4], uint32(len(query)))
 	copy(body[4:], []byte(query))
-	opcode, body, err := cn.retrySendRecv(func() error {
-		return cn.send(opPrepare, body)
-	})
+	opcode, body, err := cn.retryCall(opPrepare, body)
 	if err != nil {
 		return nil, err
 	}
@@ -400,9 +616,9 @@ func (cn *connection) Prepare(query string) (driver.Stmt, error) {
 	}
 	n := int(binary.BigEndian.Uint16(body[4:]))
 	prepared := body[6 : 6+n]
-	columns, meta, _ := parseMeta(body[6+n:])
+	columns, meta, _, _ := parseMeta(body[6+n:])
 	return &statement{cn: cn, query: query,
-		prepared: prepared, columns: columns, meta: meta}, nil
+		prepared: prepared, columns: columns, meta: meta, pageSize: cn.pageSize}, nil
 }
 
 type statement struct {
@@ -411,6 +627,9 @@ type statement struct {
 	prepared []byte
 	columns  []string
 	meta     []uint16
+
+	pageSize          int32 // v2 only; 0 disables automatic paging
+	serialConsistency byte  // v2 only; 0 means "not set"
 }
 
 func (s *statement) Close() error {
@@ -425,33 +644,86 @@ func (st *statement) NumInput() int {
 	return len(st.columns)
 }
 
-func parseMeta(body []byte) ([]string, []uint16, int) {
+// SetPageSize overrides the connection's default automatic-paging page
+// size for this statement; n <= 0 disables paging. Callers that need this
+// type-assert their driver.Stmt to Pager, since database/sql only hands
+// back the driver.Stmt interface.
+func (st *statement) SetPageSize(n int) {
+	st.pageSize = int32(n)
+}
+
+// SetSerialConsistency sets the consistency level gocql uses for the
+// conditional (IF ...) half of a lightweight transaction. Only meaningful
+// over protocol v2+.
+func (st *statement) SetSerialConsistency(cl string) error {
+	b, err := parseConsistency(cl)
+	if err != nil {
+		return err
+	}
+	st.serialConsistency = b
+	return nil
+}
+
+// Pager is implemented by prepared statements that support a per-statement
+// override of the connection's default automatic-paging page size.
+type Pager interface {
+	SetPageSize(n int)
+}
+
+// parseMeta decodes a RESULT metadata block (shared by PREPARED and ROWS
+// results): a flags word, a column count, an optional paging state (v2+,
+// when the HAS_MORE_PAGES flag is set) and, unless NO_METADATA is set, the
+// column names and CQL type ids themselves.
+func parseMeta(body []byte) (columns []string, types []uint16, pagingState []byte, consumed int) {
+	const (
+		metaFlagGlobalTableSpec = 0x0001
+		metaFlagHasMorePages    = 0x0002
+		metaFlagNoMetadata      = 0x0004
+	)
 	flags := binary.BigEndian.Uint32(body)
-	globalTableSpec := flags&1 == 1
 	columnCount := int(binary.BigEndian.Uint32(body[4:]))
 	i := 8
-	if globalTableSpec {
+	if flags&metaFlagHasMorePages != 0 {
+		l := int(binary.BigEndian.Uint32(body[i:]))
+		i += 4
+		pagingState = body[i : i+l]
+		i += l
+	}
+	if flags&metaFlagNoMetadata != 0 {
+		return nil, nil, pagingState, i
+	}
+	if flags&metaFlagGlobalTableSpec != 0 {
 		l := int(binary.BigEndian.Uint16(body[i:]))
-		keyspace := string(body[i+2 : i+2+l])
-		i += 2 + l
+		i += 2 + l // keyspace name
 		l = int(binary.BigEndian.Uint16(body[i:]))
-		tablename := string(body[i+2 : i+2+l])
-		i += 2 + l
-		_, _ = keyspace, tablename
+		i += 2 + l // table name
 	}
-	columns := make([]string, columnCount)
-	meta := make([]uint16, columnCount)
+	columns = make([]string, columnCount)
+	types = make([]uint16, columnCount)
 	for c := 0; c < columnCount; c++ {
 		l := int(binary.BigEndian.Uint16(body[i:]))
 		columns[c] = string(body[i+2 : i+2+l])
 		i += 2 + l
-		meta[c] = binary.BigEndian.Uint16(body[i:])
+		types[c] = binary.BigEndian.Uint16(body[i:])
 		i += 2
+		// custom and collection type ids are followed by an [option]
+		// that must be skipped to reach the next column spec.
+		switch types[c] {
+		case typeCustom:
+			i += 2 + int(binary.BigEndian.Uint16(body[i:])) // class name
+		case typeList, typeSet:
+			i += 2 // element type id
+		case typeMap:
+			i += 4 // key and value type ids
+		}
 	}
-	return columns, meta, i
+	return columns, types, pagingState, i
 }
 
-func (st *statement) exec(v []driver.Value, consistency byte) error {
+// exec builds a v1 EXECUTE body: stream-multiplexing and LZ4/Snappy aside,
+// this is the original wire format, kept around via the "protocol=1" DSN
+// option and its []byte-only bound values.
+func (st *statement) exec(v []driver.Value, consistency byte) ([]byte, error) {
 	sz := 6 + len(st.prepared)
 	for i := range v {
 		if b, ok := v[i].([]byte); ok {
@@ -465,30 +737,62 @@ func (st *statement) exec(v []driver.Value, consistency byte) error {
 	for i := range v {
 		b, ok := v[i].([]byte)
 		if !ok {
-			return fmt.Errorf("unsupported type %T at column %d", v[i], i)
+			return nil, fmt.Errorf("unsupported type %T at column %d", v[i], i)
 		}
 		binary.BigEndian.PutUint32(body[p:], uint32(len(b)))
 		copy(body[p+4:], b)
 		p += 4 + len(b)
 	}
 	binary.BigEndian.PutUint16(body[p:], uint16(consistency))
-	if err := st.cn.send(opExecute, body); err != nil {
-		return err
+	return body, nil
+}
+
+// encodeValues turns bound driver.Values into their wire form, using the
+// CQL type ids read from the PREPARE response. A []byte value is passed
+// through untouched so existing callers that already do their own
+// marshaling keep working unchanged.
+func (st *statement) encodeValues(v []driver.Value) ([][]byte, error) {
+	out := make([][]byte, len(v))
+	for i := range v {
+		if b, ok := v[i].([]byte); ok {
+			out[i] = b
+			continue
+		}
+		var typ uint16
+		if i < len(st.meta) {
+			typ = st.meta[i]
+		}
+		b, err := encodeValue(v[i], typ)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %s", i, err)
+		}
+		out[i] = b
 	}
-	return nil
+	return out, nil
 }
 
 func (st *statement) Exec(v []driver.Value) (driver.Result, error) {
 	if err := st.cn.recycleErr(); err != nil {
 		return nil, err
 	}
-	opcode, body, err := st.cn.retrySendRecv(func() error {
-		return st.exec(v, st.cn.writeConsistency)
-	})
+	if st.cn.protocolVersion < 2 {
+		body, err := st.exec(v, st.cn.writeConsistency)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := st.cn.retryCall(opExecute, body); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	values, err := st.encodeValues(v)
 	if err != nil {
 		return nil, err
 	}
-	_, _ = opcode, body
+	body := buildExecuteV2(st.prepared, values, st.cn.writeConsistency, 0, nil, st.serialConsistency)
+	if _, _, err := st.cn.retryCall(opExecute, body); err != nil {
+		return nil, err
+	}
 	return nil, nil
 }
 
@@ -496,26 +800,36 @@ func (st *statement) Query(v []driver.Value) (driver.Rows, error) {
 	if err := st.cn.recycleErr(); err != nil {
 		return nil, err
 	}
-	opcode, body, err := st.cn.retrySendRecv(func() error {
-		return st.exec(v, st.cn.readConsistency)
-	})
+	if st.cn.protocolVersion < 2 {
+		body, err := st.exec(v, st.cn.readConsistency)
+		if err != nil {
+			return nil, err
+		}
+		opcode, body, err := st.cn.retryCall(opExecute, body)
+		if err != nil {
+			return nil, err
+		}
+		kind := binary.BigEndian.Uint32(body[0:4])
+		if opcode != opResult || kind != 2 {
+			return nil, fmt.Errorf("expected rows as result")
+		}
+		columns, meta, _, n := parseMeta(body[4:])
+		i := n + 4
+		rows := &rows{
+			columns: columns,
+			meta:    meta,
+			numRows: int(binary.BigEndian.Uint32(body[i:])),
+		}
+		i += 4
+		rows.body = body[i:]
+		return rows, nil
+	}
+
+	values, err := st.encodeValues(v)
 	if err != nil {
 		return nil, err
 	}
-	kind := binary.BigEndian.Uint32(body[0:4])
-	if opcode != opResult || kind != 2 {
-		return nil, fmt.Errorf("expected rows as result")
-	}
-	columns, meta, n := parseMeta(body[4:])
-	i := n + 4
-	rows := &rows{
-		columns: columns,
-		meta:    meta,
-		numRows: int(binary.BigEndian.Uint32(body[i:])),
-	}
-	i += 4
-	rows.body = body[i:]
-	return rows, nil
+	return st.fetchPage(values, nil)
 }
 
 type rows struct {
@@ -524,6 +838,13 @@ type rows struct {
 	body    []byte
 	row     int
 	numRows int
+
+	// set when automatic paging is in play (protocol v2+ with a non-empty
+	// paging state): stmt and values let Next re-issue the EXECUTE for the
+	// next page once the current one is exhausted.
+	stmt        *statement
+	values      [][]byte
+	pagingState []byte
 }
 
 func (r *rows) Close() error {
@@ -535,8 +856,18 @@ func (r *rows) Columns() []string {
 }
 
 func (r *rows) Next(values []driver.Value) error {
-	if r.row >= r.numRows {
-		return io.EOF
+	for r.row >= r.numRows {
+		if len(r.pagingState) == 0 || r.stmt == nil {
+			return io.EOF
+		}
+		next, err := r.stmt.fetchPage(r.values, r.pagingState)
+		if err != nil {
+			return err
+		}
+		*r = *next.(*rows)
+		// Cassandra can legitimately hand back an empty page that isn't
+		// the last one; loop around to fetch the next page instead of
+		// reporting EOF while a pagingState is still available.
 	}
 	for column := 0; column < len(r.columns); column++ {
 		n := int32(binary.BigEndian.Uint32(r.body))
@@ -0,0 +1,319 @@
+// Copyright (c) 2012 The gocql Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gocql
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+// CQL type ids, as carried in a RESULT/PREPARED metadata block and read
+// into statement.meta. typeCustom doubles as "no column metadata is
+// available", used by Batch.Query for bare (unprepared) statements.
+const (
+	typeCustom    uint16 = 0x0000
+	typeAscii     uint16 = 0x0001
+	typeBigInt    uint16 = 0x0002
+	typeBlob      uint16 = 0x0003
+	typeBoolean   uint16 = 0x0004
+	typeCounter   uint16 = 0x0005
+	typeDecimal   uint16 = 0x0006
+	typeDouble    uint16 = 0x0007
+	typeFloat     uint16 = 0x0008
+	typeInt       uint16 = 0x0009
+	typeText      uint16 = 0x000A
+	typeTimestamp uint16 = 0x000B
+	typeUUID      uint16 = 0x000C
+	typeVarchar   uint16 = 0x000D
+	typeVarint    uint16 = 0x000E
+	typeTimeUUID  uint16 = 0x000F
+	typeInet      uint16 = 0x0010
+	typeList      uint16 = 0x0020
+	typeMap       uint16 = 0x0021
+	typeSet       uint16 = 0x0022
+)
+
+// UUID is a 16-byte CQL uuid/timeuuid value.
+type UUID [16]byte
+
+// encodeValue marshals a bound driver.Value into its native protocol wire
+// form, dispatching on the CQL type id typ read from a PREPARE response. A
+// nil value encodes as CQL NULL, represented here as a nil []byte (not an
+// error and not a zero-length slice, which is a valid empty string/blob).
+// typeCustom means no column metadata is available (e.g. a bare statement
+// in a Batch) and instead infers the wire form from v's Go type alone.
+func encodeValue(v driver.Value, typ uint16) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if b, ok := v.([]byte); ok {
+		return b, nil // already wire form
+	}
+
+	if typ == typeCustom {
+		return encodeByGoType(v)
+	}
+
+	switch typ {
+	case typeAscii, typeVarchar, typeText:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string for CQL type 0x%x, got %T", typ, v)
+		}
+		return []byte(s), nil
+	case typeBoolean:
+		bo, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected bool for CQL type 0x%x, got %T", typ, v)
+		}
+		if bo {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case typeInt:
+		n, ok := toInt64(v)
+		if !ok {
+			return nil, fmt.Errorf("expected integer for CQL type 0x%x, got %T", typ, v)
+		}
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(n))
+		return b, nil
+	case typeBigInt, typeCounter, typeVarint:
+		n, ok := toInt64(v)
+		if !ok {
+			return nil, fmt.Errorf("expected integer for CQL type 0x%x, got %T", typ, v)
+		}
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(n))
+		return b, nil
+	case typeTimestamp:
+		switch t := v.(type) {
+		case time.Time:
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(t.UnixNano()/int64(time.Millisecond)))
+			return b, nil
+		default:
+			n, ok := toInt64(v)
+			if !ok {
+				return nil, fmt.Errorf("expected time.Time or millis for CQL type 0x%x, got %T", typ, v)
+			}
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(n))
+			return b, nil
+		}
+	case typeFloat:
+		f, ok := v.(float32)
+		if !ok {
+			return nil, fmt.Errorf("expected float32 for CQL type 0x%x, got %T", typ, v)
+		}
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, math.Float32bits(f))
+		return b, nil
+	case typeDouble:
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected float64 for CQL type 0x%x, got %T", typ, v)
+		}
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(f))
+		return b, nil
+	case typeUUID, typeTimeUUID:
+		u, ok := v.(UUID)
+		if !ok {
+			return nil, fmt.Errorf("expected gocql.UUID for CQL type 0x%x, got %T", typ, v)
+		}
+		return u[:], nil
+	case typeBlob:
+		return nil, fmt.Errorf("expected []byte for CQL type 0x%x, got %T", typ, v)
+	default:
+		return nil, fmt.Errorf("gocql: no typed encoder for CQL type 0x%x (value %T)", typ, v)
+	}
+}
+
+// encodeByGoType infers a wire encoding purely from v's Go type, for
+// callers (Batch.Query) that have no column metadata to dispatch on.
+func encodeByGoType(v driver.Value) ([]byte, error) {
+	switch vv := v.(type) {
+	case string:
+		return []byte(vv), nil
+	case bool:
+		if vv {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case int32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(vv))
+		return b, nil
+	case int64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(vv))
+		return b, nil
+	case int:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(int64(vv)))
+		return b, nil
+	case float32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, math.Float32bits(vv))
+		return b, nil
+	case float64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(vv))
+		return b, nil
+	case time.Time:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(vv.UnixNano()/int64(time.Millisecond)))
+		return b, nil
+	case UUID:
+		return vv[:], nil
+	default:
+		return nil, fmt.Errorf("gocql: unsupported type %T", v)
+	}
+}
+
+// toInt64 accepts the handful of Go integer types database/sql hands
+// drivers (int64 from driver.DefaultParameterConverter, plus int/int32 for
+// callers that bypass it) and normalizes them to int64.
+func toInt64(v driver.Value) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// decode reverses encodeValue: given a column's raw wire bytes and its
+// CQL type id, it returns the Go value rows.Next (and querySystemTable's
+// internal row decoding) hands back to the caller. A nil data means the
+// column was CQL NULL and decodes to nil regardless of typ.
+func decode(data []byte, typ uint16) interface{} {
+	if data == nil {
+		return nil
+	}
+	switch typ {
+	case typeAscii, typeVarchar, typeText:
+		return string(data)
+	case typeBoolean:
+		return len(data) > 0 && data[0] != 0
+	case typeInt:
+		return int32(binary.BigEndian.Uint32(data))
+	case typeBigInt, typeCounter, typeVarint:
+		return int64(binary.BigEndian.Uint64(data))
+	case typeTimestamp:
+		millis := int64(binary.BigEndian.Uint64(data))
+		return time.Unix(0, millis*int64(time.Millisecond))
+	case typeFloat:
+		return math.Float32frombits(binary.BigEndian.Uint32(data))
+	case typeDouble:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	case typeUUID, typeTimeUUID:
+		var u UUID
+		copy(u[:], data)
+		return u
+	case typeInet:
+		return net.IP(data).String()
+	case typeList, typeSet:
+		return decodeTextCollection(data)
+	case typeMap:
+		return decodeTextMap(data)
+	default:
+		// typeBlob and typeCustom have no further structure to decode;
+		// hand the raw bytes back as-is.
+		return data
+	}
+}
+
+// decodeTextCollection decodes a list<text>/set<text> value: a 2-byte
+// element count followed by each element as a 2-byte length and its
+// bytes. This is the only element type gocql has a caller for today
+// (decodeTokens, over system.local/system.peers' "tokens" column).
+func decodeTextCollection(data []byte) []string {
+	if len(data) < 2 {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	out := make([]string, 0, n)
+	for i := 0; i < n && len(data) >= 2; i++ {
+		l := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if l < 0 || l > len(data) {
+			break
+		}
+		out = append(out, string(data[:l]))
+		data = data[l:]
+	}
+	return out
+}
+
+// decodeTextMap decodes a map<text,text> value using the same 2-byte
+// count/length framing as decodeTextCollection, alternating key and
+// value.
+func decodeTextMap(data []byte) map[string]string {
+	if len(data) < 2 {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	out := make(map[string]string, n)
+	for i := 0; i < n && len(data) >= 2; i++ {
+		kl := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if kl < 0 || kl > len(data) {
+			break
+		}
+		key := string(data[:kl])
+		data = data[kl:]
+
+		if len(data) < 2 {
+			break
+		}
+		vl := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if vl < 0 || vl > len(data) {
+			break
+		}
+		out[key] = string(data[:vl])
+		data = data[vl:]
+	}
+	return out
+}
+
+// columnEncoder adapts a prepared statement's column type ids to the
+// database/sql driver.ValueConverter interface, so database/sql converts
+// bound arguments straight to their CQL wire form via encodeValue rather
+// than through DefaultParameterConverter's generic int64/string/etc.
+type columnEncoder struct {
+	meta []uint16
+}
+
+func (e *columnEncoder) ColumnConverter(idx int) driver.ValueConverter {
+	var typ uint16
+	if idx < len(e.meta) {
+		typ = e.meta[idx]
+	}
+	return columnTypeConverter{typ}
+}
+
+// columnTypeConverter converts a single column's bound value to wire
+// form via encodeValue, which already handles nil and pass-through
+// []byte values.
+type columnTypeConverter struct {
+	typ uint16
+}
+
+func (c columnTypeConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	return encodeValue(v, c.typ)
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2012 The gocql Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package migrate is a small schema migration runner for gocql, in the
+// spirit of xormigrate: an ordered list of Migrations is applied against
+// a keyspace, with progress tracked in a gocql_migrations table.
+//
+// Cassandra has no multi-statement transactions. The *sql.Tx a Migration
+// runs against comes from the driver's existing Begin/Commit sequence,
+// which is bookkeeping only -- each statement a Migration issues takes
+// effect as soon as it's sent. If a Migration's Up or Down returns an
+// error partway through, whichever statements it already issued remain
+// applied; failure semantics are per-statement, not per-migration. Write
+// migrations so each statement is safe to re-run, and keep a Migration
+// to one statement where that matters.
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration is one ordered, named schema change. Up and Down run against
+// the *sql.Tx the Migrator obtains from db.Begin for the migration.
+type Migration struct {
+	ID   string
+	Up   func(*sql.Tx) error
+	Down func(*sql.Tx) error
+}
+
+// trackingTable records which Migrations have already been applied.
+const trackingTable = `CREATE TABLE IF NOT EXISTS gocql_migrations (
+	id text PRIMARY KEY,
+	applied_at timestamp
+)`
+
+// Status reports whether a single Migration has been applied.
+type Status struct {
+	ID      string
+	Applied bool
+}
+
+// Migrator applies an ordered list of Migrations against db. Set DryRun
+// to have Up, Down and To call each pending Migration's Up/Down with a
+// nil *sql.Tx instead of running it against the keyspace; a Migration
+// that wants dry-run support should treat a nil tx as "describe, don't
+// execute" and print the CQL it would have run (see cmd/gocql-migrate's
+// execFile). Status, and the read of which migrations are already
+// applied, always hit the real keyspace so a dry run reflects its
+// actual state.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+
+	DryRun bool
+}
+
+// NewMigrator returns a Migrator that applies migrations, in the order
+// given, against db.
+func NewMigrator(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+func (m *Migrator) ensureTable() error {
+	_, err := m.db.Exec(trackingTable)
+	return err
+}
+
+// applied returns the set of migration IDs already recorded in
+// gocql_migrations, creating the table first if it doesn't exist. This
+// runs for real even when DryRun is set, so Status and the pending/
+// applied split Up, Down and To compute against it reflect the
+// keyspace's actual state rather than an empty table.
+func (m *Migrator) applied() (map[string]bool, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, fmt.Errorf("migrate: create tracking table: %s", err)
+	}
+	applied := make(map[string]bool)
+	rows, err := m.db.Query(`SELECT id FROM gocql_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: list applied migrations: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		applied[id] = true
+	}
+	return applied, rows.Err()
+}
+
+// runUp applies mig.Up and records it. When DryRun is set, it instead
+// calls mig.Up with a nil *sql.Tx and records nothing; Up funcs built
+// around a single Exec per statement (see cmd/gocql-migrate's execFile)
+// use that nil to print the statement instead of running it.
+func (m *Migrator) runUp(mig Migration) error {
+	if m.DryRun {
+		if err := mig.Up(nil); err != nil {
+			return fmt.Errorf("migrate: up %s: %s", mig.ID, err)
+		}
+		return nil
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("migrate: begin %s: %s", mig.ID, err)
+	}
+	if err := mig.Up(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate: up %s: %s", mig.ID, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO gocql_migrations (id, applied_at) VALUES (?, ?)`, mig.ID, time.Now()); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate: record %s: %s", mig.ID, err)
+	}
+	return tx.Commit()
+}
+
+// runDown applies mig.Down and unrecords it, following the same nil-tx
+// convention as runUp when DryRun is set.
+func (m *Migrator) runDown(mig Migration) error {
+	if mig.Down == nil {
+		return fmt.Errorf("migrate: %s has no Down", mig.ID)
+	}
+	if m.DryRun {
+		if err := mig.Down(nil); err != nil {
+			return fmt.Errorf("migrate: down %s: %s", mig.ID, err)
+		}
+		return nil
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("migrate: begin %s: %s", mig.ID, err)
+	}
+	if err := mig.Down(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate: down %s: %s", mig.ID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM gocql_migrations WHERE id = ?`, mig.ID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate: unrecord %s: %s", mig.ID, err)
+	}
+	return tx.Commit()
+}
+
+// Up applies every pending migration, in order.
+func (m *Migrator) Up() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for _, mig := range m.migrations {
+		if applied[mig.ID] {
+			continue
+		}
+		if err := m.runUp(mig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down rolls back the single most recently applied migration.
+func (m *Migrator) Down() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if applied[m.migrations[i].ID] {
+			return m.runDown(m.migrations[i])
+		}
+	}
+	return nil
+}
+
+// To migrates up or down until id is the most recently applied
+// migration. id must name one of the Migrator's migrations.
+func (m *Migrator) To(id string) error {
+	idx := -1
+	for i, mig := range m.migrations {
+		if mig.ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return fmt.Errorf("migrate: unknown migration %q", id)
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for i := len(m.migrations) - 1; i > idx; i-- {
+		if applied[m.migrations[i].ID] {
+			if err := m.runDown(m.migrations[i]); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 0; i <= idx; i++ {
+		if !applied[m.migrations[i].ID] {
+			if err := m.runUp(m.migrations[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Status reports every migration, in order, and whether it has been
+// applied.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		statuses[i] = Status{ID: mig.ID, Applied: applied[mig.ID]}
+	}
+	return statuses, nil
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2012 The gocql Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gocql
+
+import "math"
+
+// murmur3Token computes the 64-bit token Cassandra's Murmur3Partitioner
+// assigns to a partition key: the first half (h1) of a 128-bit x64 murmur3
+// hash of the key, seeded with 0. This mirrors
+// org.apache.cassandra.dht.Murmur3Partitioner.getToken.
+func murmur3Token(data []byte) int64 {
+	const (
+		c1 = uint64(0x87c37b91114253d5)
+		c2 = uint64(0x4cf5ad432745937f)
+	)
+
+	length := len(data)
+	nblocks := length / 16
+
+	h1 := uint64(0)
+	h2 := uint64(0)
+
+	for i := 0; i < nblocks; i++ {
+		b := data[i*16 : i*16+16]
+		k1 := le64(b[0:8])
+		k2 := le64(b[8:16])
+
+		k1 *= c1
+		k1 = rotl64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+
+		h1 = rotl64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= c2
+		k2 = rotl64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+
+		h2 = rotl64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	// Cassandra's MurmurHash.hash3_x64_128 mixes tail bytes in as a Java
+	// signed byte widened to long, not the unsigned getblock it uses for
+	// full 16-byte blocks -- so, unlike le64 above, every tail byte here
+	// must go through int8 to sign-extend before the uint64 conversion.
+	tail := data[nblocks*16:]
+	var k1, k2 uint64
+	switch len(tail) {
+	case 15:
+		k2 ^= uint64(int8(tail[14])) << 48
+		fallthrough
+	case 14:
+		k2 ^= uint64(int8(tail[13])) << 40
+		fallthrough
+	case 13:
+		k2 ^= uint64(int8(tail[12])) << 32
+		fallthrough
+	case 12:
+		k2 ^= uint64(int8(tail[11])) << 24
+		fallthrough
+	case 11:
+		k2 ^= uint64(int8(tail[10])) << 16
+		fallthrough
+	case 10:
+		k2 ^= uint64(int8(tail[9])) << 8
+		fallthrough
+	case 9:
+		k2 ^= uint64(int8(tail[8]))
+		k2 *= c2
+		k2 = rotl64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+		fallthrough
+	case 8:
+		k1 ^= uint64(int8(tail[7])) << 56
+		fallthrough
+	case 7:
+		k1 ^= uint64(int8(tail[6])) << 48
+		fallthrough
+	case 6:
+		k1 ^= uint64(int8(tail[5])) << 40
+		fallthrough
+	case 5:
+		k1 ^= uint64(int8(tail[4])) << 32
+		fallthrough
+	case 4:
+		k1 ^= uint64(int8(tail[3])) << 24
+		fallthrough
+	case 3:
+		k1 ^= uint64(int8(tail[2])) << 16
+		fallthrough
+	case 2:
+		k1 ^= uint64(int8(tail[1])) << 8
+		fallthrough
+	case 1:
+		k1 ^= uint64(int8(tail[0]))
+		k1 *= c1
+		k1 = rotl64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+	}
+
+	h1 ^= uint64(length)
+	h2 ^= uint64(length)
+
+	h1 += h2
+	h2 += h1
+
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+
+	h1 += h2
+
+	token := int64(h1)
+	if token == math.MinInt64 {
+		// Murmur3Partitioner.getToken normalizes away MIN_VALUE so every
+		// token has a well-defined predecessor on the ring.
+		return math.MaxInt64
+	}
+	return token
+}
+
+func le64(b []byte) uint64 {
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+}
+
+func rotl64(x uint64, r uint) uint64 {
+	return (x << r) | (x >> (64 - r))
+}
+
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
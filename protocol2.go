@@ -0,0 +1,207 @@
+// Copyright (c) 2012 The gocql Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gocql
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+)
+
+// Flags on a v2 QUERY/EXECUTE body.
+const (
+	flagValues            byte = 0x01
+	flagSkipMetadata      byte = 0x02
+	flagPageSize          byte = 0x04
+	flagPagingState       byte = 0x08
+	flagSerialConsistency byte = 0x10
+)
+
+// BatchType selects how a Batch is applied; see NewBatch.
+type BatchType byte
+
+const (
+	BatchLogged   BatchType = 0x00
+	BatchUnlogged BatchType = 0x01
+	BatchCounter  BatchType = 0x02
+)
+
+// buildQuery builds a v1 or v2 QUERY body depending on protocolVersion. It
+// has no bound values, so it's only meant for ad-hoc statements such as
+// the discovery queries Cluster issues against system.local/system.peers.
+func buildQuery(protocolVersion byte, cql string, consistency byte) []byte {
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.BigEndian, uint32(len(cql)))
+	b.WriteString(cql)
+	binary.Write(b, binary.BigEndian, uint16(consistency))
+	if protocolVersion >= 2 {
+		b.WriteByte(0) // no values, no paging
+	}
+	return b.Bytes()
+}
+
+// buildExecuteV2 builds a v2 EXECUTE body: <id><consistency><flags>
+// [<n><value_1>...][<page_size>][<paging_state>][<serial_consistency>],
+// including only the optional sections the caller actually asked for.
+func buildExecuteV2(prepared []byte, values [][]byte, consistency byte, pageSize int32, pagingState []byte, serialConsistency byte) []byte {
+	var flags byte
+	if len(values) > 0 {
+		flags |= flagValues
+	}
+	if pageSize > 0 {
+		flags |= flagPageSize
+	}
+	if len(pagingState) > 0 {
+		flags |= flagPagingState
+	}
+	if serialConsistency != 0 {
+		flags |= flagSerialConsistency
+	}
+
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.BigEndian, uint16(len(prepared)))
+	b.Write(prepared)
+	binary.Write(b, binary.BigEndian, uint16(consistency))
+	b.WriteByte(flags)
+	if flags&flagValues != 0 {
+		writeValues(b, values)
+	}
+	if flags&flagPageSize != 0 {
+		binary.Write(b, binary.BigEndian, pageSize)
+	}
+	if flags&flagPagingState != 0 {
+		binary.Write(b, binary.BigEndian, uint32(len(pagingState)))
+		b.Write(pagingState)
+	}
+	if flags&flagSerialConsistency != 0 {
+		binary.Write(b, binary.BigEndian, uint16(serialConsistency))
+	}
+	return b.Bytes()
+}
+
+// writeValues appends a [<n:short><value_1>...] block, encoding a nil
+// entry as the wire NULL (a -1 length with no following bytes).
+func writeValues(b *bytes.Buffer, values [][]byte) {
+	binary.Write(b, binary.BigEndian, uint16(len(values)))
+	for _, v := range values {
+		if v == nil {
+			binary.Write(b, binary.BigEndian, int32(-1))
+			continue
+		}
+		binary.Write(b, binary.BigEndian, uint32(len(v)))
+		b.Write(v)
+	}
+}
+
+// fetchPage issues (or re-issues, via pagingState) a v2 EXECUTE for st and
+// wraps the ROWS result in a *rows that knows how to fetch its successor.
+func (st *statement) fetchPage(values [][]byte, pagingState []byte) (driver.Rows, error) {
+	body := buildExecuteV2(st.prepared, values, st.cn.readConsistency, st.pageSize, pagingState, st.serialConsistency)
+	opcode, body, err := st.cn.retryCall(opExecute, body)
+	if err != nil {
+		return nil, err
+	}
+	kind := binary.BigEndian.Uint32(body[0:4])
+	if opcode != opResult || kind != 2 {
+		return nil, fmt.Errorf("expected rows as result")
+	}
+	columns, meta, newPagingState, n := parseMeta(body[4:])
+	i := n + 4
+	numRows := int(binary.BigEndian.Uint32(body[i:]))
+	i += 4
+	return &rows{
+		columns:     columns,
+		meta:        meta,
+		numRows:     numRows,
+		body:        body[i:],
+		stmt:        st,
+		values:      values,
+		pagingState: newPagingState,
+	}, nil
+}
+
+// batchEntry is one statement inside a Batch: either a bare query string
+// or a prepared statement id, plus its already-encoded bound values.
+type batchEntry struct {
+	prepared []byte // nil for a bare query
+	query    string // unused if prepared != nil
+	values   [][]byte
+}
+
+// Batch groups several statements into a single BATCH frame that
+// Cassandra applies atomically (within a single partition for LOGGED/
+// UNLOGGED, or as a counter update for COUNTER).
+type Batch struct {
+	cn          *connection
+	kind        BatchType
+	consistency byte
+	entries     []batchEntry
+}
+
+// NewBatch creates an empty batch of the given kind on cn, using the
+// connection's write consistency by default.
+func (cn *connection) NewBatch(kind BatchType) *Batch {
+	return &Batch{cn: cn, kind: kind, consistency: cn.writeConsistency}
+}
+
+// Query appends a bare (unprepared) statement to the batch. values are
+// encoded from their Go types since there's no prepared metadata to
+// dispatch on; pass already-wire-form []byte values to bypass that.
+func (b *Batch) Query(query string, values ...driver.Value) error {
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		enc, err := encodeValue(v, typeCustom)
+		if err != nil {
+			return fmt.Errorf("column %d: %s", i, err)
+		}
+		encoded[i] = enc
+	}
+	b.entries = append(b.entries, batchEntry{query: query, values: encoded})
+	return nil
+}
+
+// Prepared appends an already-prepared statement to the batch, dispatching
+// value encoding on its known column types like Exec/Query do.
+func (b *Batch) Prepared(st *statement, values ...driver.Value) error {
+	encoded, err := st.encodeValues(values)
+	if err != nil {
+		return err
+	}
+	b.entries = append(b.entries, batchEntry{prepared: st.prepared, values: encoded})
+	return nil
+}
+
+// Exec sends the BATCH frame and waits for Cassandra to apply it.
+func (b *Batch) Exec() error {
+	if b.cn.protocolVersion < 2 {
+		return fmt.Errorf("gocql: BATCH requires protocol version 2, connection negotiated %d", b.cn.protocolVersion)
+	}
+	body := &bytes.Buffer{}
+	body.WriteByte(byte(b.kind))
+	binary.Write(body, binary.BigEndian, uint16(len(b.entries)))
+	for _, e := range b.entries {
+		if e.prepared != nil {
+			body.WriteByte(1)
+			binary.Write(body, binary.BigEndian, uint16(len(e.prepared)))
+			body.Write(e.prepared)
+		} else {
+			body.WriteByte(0)
+			binary.Write(body, binary.BigEndian, uint32(len(e.query)))
+			body.WriteString(e.query)
+		}
+		writeValues(body, e.values)
+	}
+	binary.Write(body, binary.BigEndian, uint16(b.consistency))
+
+	opcode, _, err := b.cn.retryCall(opBatch, body.Bytes())
+	if err != nil {
+		return err
+	}
+	if opcode != opResult {
+		return fmt.Errorf("gocql: unexpected response to BATCH: opcode 0x%x", opcode)
+	}
+	return nil
+}